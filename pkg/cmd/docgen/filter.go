@@ -0,0 +1,407 @@
+// Copyright 2017 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// filterRecord is the set of fields the filter DSL can query. funcDoc and
+// opDoc both know how to describe themselves as one, so the same predicate
+// can be evaluated against catalogFunctions' functions and
+// catalogOperators' overload rows.
+type filterRecord struct {
+	category    string
+	name        string
+	returns     string
+	argTypes    []string
+	isAggregate bool
+	isWindow    bool
+	info        string
+}
+
+func (fn funcDoc) filterRecord() filterRecord {
+	return filterRecord{
+		category:    fn.Category,
+		name:        fn.Name,
+		returns:     fn.ReturnType,
+		argTypes:    splitArgTypes(fn.Args),
+		isAggregate: fn.Aggregate,
+		isWindow:    fn.Window,
+		info:        fn.Info,
+	}
+}
+
+func (o opDoc) filterRecord() filterRecord {
+	return filterRecord{
+		name:     o.Op,
+		returns:  o.ReturnType,
+		argTypes: splitArgTypes(strings.Trim(o.Left+", "+o.Right, ", ")),
+	}
+}
+
+func splitArgTypes(args string) []string {
+	if args == "" {
+		return nil
+	}
+	parts := strings.Split(args, ", ")
+	out := parts[:0]
+	for _, p := range parts {
+		if p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// filterExpr is one node of a parsed --filter expression.
+type filterExpr interface {
+	eval(r filterRecord) bool
+	String() string
+	// mentionsField reports whether f appears in any comparison in this
+	// expression, so callers can tell an explicit predicate on f apart from
+	// one that never mentions it.
+	mentionsField(f filterField) bool
+}
+
+type filterField string
+
+const (
+	fieldCategory     filterField = "category"
+	fieldName         filterField = "name"
+	fieldReturns      filterField = "returns"
+	fieldArgType      filterField = "arg_type"
+	fieldIsAggregate  filterField = "is_aggregate"
+	fieldIsWindow     filterField = "is_window"
+	fieldInfoContains filterField = "info_contains"
+)
+
+var filterFields = map[string]filterField{
+	string(fieldCategory):     fieldCategory,
+	string(fieldName):         fieldName,
+	string(fieldReturns):      fieldReturns,
+	string(fieldArgType):      fieldArgType,
+	string(fieldIsAggregate):  fieldIsAggregate,
+	string(fieldIsWindow):     fieldIsWindow,
+	string(fieldInfoContains): fieldInfoContains,
+}
+
+type cmpOp string
+
+const (
+	cmpEq       cmpOp = "="
+	cmpNeq      cmpOp = "!="
+	cmpContains cmpOp = "contains"
+	cmpIn       cmpOp = "in"
+)
+
+// comparison is a leaf predicate, e.g. `category = 'JSONB'` or
+// `arg_type in ('int', 'decimal')`.
+type comparison struct {
+	field  filterField
+	op     cmpOp
+	values []string
+}
+
+func (c comparison) String() string {
+	if len(c.values) == 1 {
+		return fmt.Sprintf("%s %s %q", c.field, c.op, c.values[0])
+	}
+	quoted := make([]string, len(c.values))
+	for i, v := range c.values {
+		quoted[i] = strconv.Quote(v)
+	}
+	return fmt.Sprintf("%s %s (%s)", c.field, c.op, strings.Join(quoted, ", "))
+}
+
+func (c comparison) eval(r filterRecord) bool {
+	switch c.field {
+	case fieldIsAggregate:
+		matches := r.isAggregate == (c.values[0] == "true")
+		return matches != (c.op == cmpNeq)
+	case fieldIsWindow:
+		matches := r.isWindow == (c.values[0] == "true")
+		return matches != (c.op == cmpNeq)
+	case fieldArgType:
+		return c.matchAny(r.argTypes)
+	}
+	var subject string
+	switch c.field {
+	case fieldCategory:
+		subject = r.category
+	case fieldName:
+		subject = r.name
+	case fieldReturns:
+		subject = r.returns
+	case fieldInfoContains:
+		subject = r.info
+	}
+	return c.matchAny([]string{subject})
+}
+
+// matchAny reports whether c's comparator is satisfied by any of subjects
+// against c.values (c.values has one element for =/!=/contains, and any
+// number for in).
+func (c comparison) matchAny(subjects []string) bool {
+	for _, subject := range subjects {
+		for _, want := range c.values {
+			switch c.op {
+			case cmpEq, cmpIn:
+				if strings.EqualFold(subject, want) {
+					return true
+				}
+			case cmpNeq:
+				if !strings.EqualFold(subject, want) {
+					return true
+				}
+			case cmpContains:
+				if strings.Contains(strings.ToLower(subject), strings.ToLower(want)) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (c comparison) mentionsField(f filterField) bool { return c.field == f }
+
+type notExpr struct{ inner filterExpr }
+
+func (n notExpr) eval(r filterRecord) bool         { return !n.inner.eval(r) }
+func (n notExpr) String() string                   { return fmt.Sprintf("not %s", n.inner) }
+func (n notExpr) mentionsField(f filterField) bool { return n.inner.mentionsField(f) }
+
+type boolExpr struct {
+	and   bool
+	left  filterExpr
+	right filterExpr
+}
+
+func (b boolExpr) eval(r filterRecord) bool {
+	if b.and {
+		return b.left.eval(r) && b.right.eval(r)
+	}
+	return b.left.eval(r) || b.right.eval(r)
+}
+
+func (b boolExpr) String() string {
+	op := "or"
+	if b.and {
+		op = "and"
+	}
+	return fmt.Sprintf("%s %s %s", b.left, op, b.right)
+}
+
+func (b boolExpr) mentionsField(f filterField) bool {
+	return b.left.mentionsField(f) || b.right.mentionsField(f)
+}
+
+// matchAllExpr is the identity filter: everything matches. It's what an
+// empty --filter compiles to.
+type matchAllExpr struct{}
+
+func (matchAllExpr) eval(filterRecord) bool         { return true }
+func (matchAllExpr) String() string                 { return "" }
+func (matchAllExpr) mentionsField(filterField) bool { return false }
+
+// filterShortcuts expand a single named flag to a canonical filter
+// expression, so `--only-window` documents itself the same way a
+// hand-written `--filter` would in the generated file's header comment.
+var filterShortcuts = map[string]string{
+	"only-window":       "is_window = true",
+	"only-aggregate":    "is_aggregate = true",
+	"exclude-pg-compat": "not info_contains contains 'PostgreSQL'",
+}
+
+// parseFilter parses a --filter expression into a filterExpr. An empty
+// string parses to matchAllExpr{}.
+func parseFilter(expr string) (filterExpr, error) {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return matchAllExpr{}, nil
+	}
+	p := &filterParser{toks: tokenizeFilter(expr)}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing --filter %q", expr)
+	}
+	if p.pos != len(p.toks) {
+		return nil, errors.Errorf("parsing --filter %q: unexpected trailing input at %q", expr, p.toks[p.pos])
+	}
+	return e, nil
+}
+
+type filterParser struct {
+	toks []string
+	pos  int
+}
+
+func (p *filterParser) peek() string {
+	if p.pos >= len(p.toks) {
+		return ""
+	}
+	return p.toks[p.pos]
+}
+
+func (p *filterParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *filterParser) parseOr() (filterExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(p.peek(), "or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = boolExpr{and: false, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(p.peek(), "and") {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = boolExpr{and: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (filterExpr, error) {
+	if strings.EqualFold(p.peek(), "not") {
+		p.next()
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{inner: inner}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *filterParser) parsePrimary() (filterExpr, error) {
+	if p.peek() == "(" {
+		p.next()
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, errors.New("expected ')'")
+		}
+		return e, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (filterExpr, error) {
+	rawField := p.next()
+	field, ok := filterFields[rawField]
+	if !ok {
+		return nil, errors.Errorf("unknown field %q (known: category, name, returns, arg_type, "+
+			"is_aggregate, is_window, info_contains)", rawField)
+	}
+	// Boolean fields may appear bare, e.g. `is_window` or `not is_window`,
+	// which is shorthand for `is_window = true`.
+	if (field == fieldIsAggregate || field == fieldIsWindow) && p.peek() != "=" && p.peek() != "!=" {
+		return comparison{field: field, op: cmpEq, values: []string{"true"}}, nil
+	}
+	op := p.next()
+	switch op {
+	case "=", "!=", "contains":
+	case "in":
+	default:
+		return nil, errors.Errorf("unknown comparator %q (known: =, !=, contains, in)", op)
+	}
+	var values []string
+	if op == "in" {
+		if p.next() != "(" {
+			return nil, errors.New("expected '(' after 'in'")
+		}
+		for {
+			values = append(values, p.next())
+			switch p.next() {
+			case ",":
+				continue
+			case ")":
+			default:
+				return nil, errors.New("expected ',' or ')' in 'in (...)' list")
+			}
+			break
+		}
+	} else {
+		values = []string{p.next()}
+	}
+	return comparison{field: field, op: cmpOp(op), values: values}, nil
+}
+
+// tokenizeFilter splits a --filter expression into identifier, operator,
+// punctuation, and (unquoted) string-literal tokens.
+func tokenizeFilter(expr string) []string {
+	var toks []string
+	i := 0
+	for i < len(expr) {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(' || c == ')' || c == ',':
+			toks = append(toks, string(c))
+			i++
+		case c == '\'' || c == '"':
+			quote := c
+			j := i + 1
+			for j < len(expr) && expr[j] != quote {
+				j++
+			}
+			toks = append(toks, expr[i+1:j])
+			i = j + 1
+		case c == '!' && i+1 < len(expr) && expr[i+1] == '=':
+			toks = append(toks, "!=")
+			i += 2
+		case c == '=':
+			toks = append(toks, "=")
+			i++
+		default:
+			j := i
+			for j < len(expr) && expr[j] != ' ' && expr[j] != '\t' && expr[j] != '\n' &&
+				expr[j] != '(' && expr[j] != ')' && expr[j] != ',' {
+				j++
+			}
+			toks = append(toks, expr[i:j])
+			i = j
+		}
+	}
+	return toks
+}
@@ -0,0 +1,109 @@
+// Copyright 2017 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFilter(t *testing.T) {
+	testCases := []struct {
+		expr    string
+		wantErr string
+	}{
+		{expr: ""},
+		{expr: "category = 'JSONB'"},
+		{expr: "category = 'JSONB' and not is_window"},
+		{expr: "is_window"},
+		{expr: "not is_window"},
+		{expr: "is_window = true"},
+		{expr: "is_aggregate != true"},
+		{expr: "arg_type in ('int', 'decimal')"},
+		{expr: "info_contains contains 'PostgreSQL'"},
+		{expr: "not info_contains contains 'PostgreSQL'"},
+		{expr: "(category = 'JSONB' or category = 'Array') and not is_aggregate"},
+		{expr: "nonsense ===", wantErr: `unknown field "nonsense"`},
+		{expr: "category ~ 'x'", wantErr: `unknown comparator "~"`},
+		{expr: "category = 'x' trailing", wantErr: "unexpected trailing input"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.expr, func(t *testing.T) {
+			_, err := parseFilter(tc.expr)
+			if tc.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.wantErr)
+		})
+	}
+}
+
+// TestFilterShortcuts verifies that every entry in filterShortcuts expands
+// to grammar that parseFilter actually accepts; a shortcut that fails to
+// parse aborts the docgen command entirely.
+func TestFilterShortcuts(t *testing.T) {
+	for flag, expr := range filterShortcuts {
+		t.Run(flag, func(t *testing.T) {
+			_, err := parseFilter(expr)
+			require.NoErrorf(t, err, "shortcut %q expands to %q", flag, expr)
+		})
+	}
+}
+
+func TestComparisonEval(t *testing.T) {
+	rec := filterRecord{
+		category:    "JSONB",
+		name:        "jsonb_build_object",
+		returns:     "jsonb",
+		argTypes:    []string{"string", "anyelement"},
+		isAggregate: false,
+		isWindow:    true,
+		info:        "Builds a JSONB object out of a variadic argument list, with PostgreSQL compatibility.",
+	}
+
+	testCases := []struct {
+		name string
+		expr string
+		want bool
+	}{
+		{name: "category eq match", expr: "category = 'JSONB'", want: true},
+		{name: "category eq no match", expr: "category = 'Array'", want: false},
+		{name: "category neq", expr: "category != 'Array'", want: true},
+		{name: "name contains", expr: "name contains 'build'", want: true},
+		{name: "returns in", expr: "returns in ('jsonb', 'json')", want: true},
+		{name: "arg_type matches any", expr: "arg_type = 'anyelement'", want: true},
+		{name: "arg_type no match", expr: "arg_type = 'int'", want: false},
+		{name: "bare is_window true", expr: "is_window", want: true},
+		{name: "not bare is_window", expr: "not is_window", want: false},
+		{name: "is_aggregate false explicit", expr: "is_aggregate = false", want: true},
+		{name: "is_aggregate neq true", expr: "is_aggregate != true", want: true},
+		{name: "info_contains match", expr: "info_contains contains 'PostgreSQL'", want: true},
+		{name: "info_contains no match", expr: "info_contains contains 'MySQL'", want: false},
+		{name: "info_contains negated", expr: "info_contains != 'MySQL'", want: true},
+		{name: "info_contains negated match", expr: "not info_contains contains 'PostgreSQL'", want: false},
+		{name: "and composition", expr: "category = 'JSONB' and is_window", want: true},
+		{name: "or composition", expr: "category = 'Array' or is_window", want: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := parseFilter(tc.expr)
+			require.NoError(t, err)
+			require.Equal(t, tc.want, f.eval(rec))
+		})
+	}
+}
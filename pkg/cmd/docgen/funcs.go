@@ -15,8 +15,7 @@
 package main
 
 import (
-	"bytes"
-	"fmt"
+	"encoding/json"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -27,13 +26,29 @@ import (
 	markdown "github.com/golang-commonmark/markdown"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v2"
 
 	"github.com/cockroachdb/cockroach/pkg/sql/parser"
 )
 
+// mdRenderer renders a builtin's Info doc comment (Markdown) to HTML for
+// embedding in a table cell; shared by the renderInfo template helper.
+var mdRenderer = markdown.New(markdown.XHTMLOutput(true), markdown.Nofollow(true))
+
+var (
+	functionsFormat          string
+	functionsFilter          string
+	functionsOnlyWindow      bool
+	functionsOnlyAggregate   bool
+	functionsExcludePgCompat bool
+	functionsTemplate        string
+	functionsOpsTemplate     string
+	functionsTypeLinks       string
+)
+
 var functionsCmd = &cobra.Command{
 	Use:   "functions <output-dir>",
-	Short: "generate markdown documentation of functions and operators",
+	Short: "generate documentation of functions and operators",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		outDir := filepath.Join("docs", "generated", "sql")
 		if len(args) > 0 {
@@ -44,26 +59,334 @@ var functionsCmd = &cobra.Command{
 			return errors.Errorf("%s does not exist", outDir)
 		}
 
-		if err := ioutil.WriteFile(
-			filepath.Join(outDir, "functions.md"), generateFunctions(parser.Builtins, true), 0644,
-		); err != nil {
+		formats, err := parseFormats(functionsFormat)
+		if err != nil {
 			return err
 		}
-		if err := ioutil.WriteFile(
-			filepath.Join(outDir, "aggregates.md"), generateFunctions(parser.Aggregates, false), 0644,
-		); err != nil {
+
+		filterStr, err := resolveFilter(
+			functionsFilter, functionsOnlyWindow, functionsOnlyAggregate, functionsExcludePgCompat,
+		)
+		if err != nil {
 			return err
 		}
-		if err := ioutil.WriteFile(
-			filepath.Join(outDir, "operators.md"), generateOperators(), 0644,
-		); err != nil {
+		filter, err := parseFilter(filterStr)
+		if err != nil {
 			return err
 		}
 
+		funcs := catalogFunctions(parser.Builtins, true, false, filterStr, filter)
+		aggs := catalogFunctions(parser.Aggregates, false, true, filterStr, filter)
+		ops := catalogOperators(filterStr, filter)
+
+		for _, format := range formats {
+			renderer, err := rendererFor(format, functionsTemplate, functionsOpsTemplate, functionsTypeLinks)
+			if err != nil {
+				return err
+			}
+			if err := writeRendered(renderer, outDir, "functions", funcs.Render); err != nil {
+				return err
+			}
+			if err := writeRendered(renderer, outDir, "aggregates", aggs.Render); err != nil {
+				return err
+			}
+			if err := writeRendered(renderer, outDir, "operators", ops.Render); err != nil {
+				return err
+			}
+		}
+
 		return nil
 	},
 }
 
+func init() {
+	functionsCmd.Flags().StringVar(
+		&functionsFormat, "format", "md",
+		"comma-separated list of output formats to generate: md, json, yaml",
+	)
+	functionsCmd.Flags().StringVar(
+		&functionsFilter, "filter", "",
+		"only emit functions/operators matching this predicate, e.g. "+
+			`"category = 'JSONB' and not is_window"`,
+	)
+	functionsCmd.Flags().BoolVar(
+		&functionsOnlyWindow, "only-window", false, "shortcut for --filter=\"is_window = true\"",
+	)
+	functionsCmd.Flags().BoolVar(
+		&functionsOnlyAggregate, "only-aggregate", false, "shortcut for --filter=\"is_aggregate = true\"",
+	)
+	functionsCmd.Flags().BoolVar(
+		&functionsExcludePgCompat, "exclude-pg-compat", false,
+		"shortcut for --filter=\"not info_contains contains 'PostgreSQL'\"",
+	)
+	functionsCmd.Flags().StringVar(
+		&functionsTemplate, "template", "",
+		"text/template file to render functions.md/aggregates.md with, "+
+			"in place of the built-in template (only used by --format=md)",
+	)
+	functionsCmd.Flags().StringVar(
+		&functionsOpsTemplate, "operators-template", "",
+		"text/template file to render operators.md with, in place of the "+
+			"built-in template (only used by --format=md)",
+	)
+	functionsCmd.Flags().StringVar(
+		&functionsTypeLinks, "type-links", "",
+		"YAML file of `type: docs-page-basename` entries, merged over the "+
+			"built-in type-link map used by the linkType template helper",
+	)
+}
+
+// resolveFilter combines an explicit --filter expression with any of the
+// predefined shortcut flags into the single canonical expression that was
+// actually applied, so it can be recorded verbatim in the generated file's
+// header comment.
+func resolveFilter(filter string, onlyWindow, onlyAggregate, excludePgCompat bool) (string, error) {
+	var clauses []string
+	if filter != "" {
+		clauses = append(clauses, filter)
+	}
+	for flag, enabled := range map[string]bool{
+		"only-window":       onlyWindow,
+		"only-aggregate":    onlyAggregate,
+		"exclude-pg-compat": excludePgCompat,
+	} {
+		if enabled {
+			clauses = append(clauses, filterShortcuts[flag])
+		}
+	}
+	if len(clauses) == 0 {
+		return "", nil
+	}
+	sort.Strings(clauses)
+	for i, c := range clauses {
+		clauses[i] = "(" + c + ")"
+	}
+	return strings.Join(clauses, " and "), nil
+}
+
+func parseFormats(raw string) ([]string, error) {
+	var formats []string
+	for _, f := range strings.Split(raw, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		formats = append(formats, f)
+	}
+	if len(formats) == 0 {
+		return nil, errors.New("--format must name at least one of: md, json, yaml")
+	}
+	return formats, nil
+}
+
+func writeRendered(
+	renderer Renderer, outDir, basename string, render func(Renderer) ([]byte, error),
+) error {
+	b, err := render(renderer)
+	if err != nil {
+		return err
+	}
+	name := basename + "." + renderer.Ext()
+	return ioutil.WriteFile(filepath.Join(outDir, name), b, 0644)
+}
+
+// Renderer turns an extracted, format-agnostic catalog into the bytes of an
+// output file. Each value accepted by --format is backed by one Renderer, so
+// that adding an output format never requires touching the code that walks
+// parser.Builtin/parser.UnaryOps/etc.
+type Renderer interface {
+	// Ext is the file extension (without a leading dot) this Renderer
+	// produces, e.g. "md" or "json".
+	Ext() string
+	// RenderFunctions renders a function/aggregate catalog, as produced by
+	// catalogFunctions.
+	RenderFunctions(doc funcCatalog) ([]byte, error)
+	// RenderOperators renders an operator catalog, as produced by
+	// catalogOperators.
+	RenderOperators(doc opCatalog) ([]byte, error)
+}
+
+// rendererFor builds the Renderer for one --format value. "md" is backed by
+// templateRenderer, which needs the --template/--operators-template/
+// --type-links paths to build; "json" and "yaml" ignore them.
+func rendererFor(format, funcsTemplatePath, opsTemplatePath, typeLinksPath string) (Renderer, error) {
+	switch format {
+	case "md":
+		return newTemplateRenderer(funcsTemplatePath, opsTemplatePath, typeLinksPath)
+	case "json":
+		return dataRenderer{ext: "json"}, nil
+	case "yaml":
+		return dataRenderer{ext: "yaml"}, nil
+	default:
+		return nil, errors.Errorf("unsupported --format %q", format)
+	}
+}
+
+// funcDoc is the structured, un-rendered description of a single builtin or
+// aggregate overload. It is the common source of truth every Renderer
+// consumes, so that e.g. IDE autocomplete and the generated Markdown never
+// drift apart.
+type funcDoc struct {
+	Name       string `json:"name" yaml:"name"`
+	Category   string `json:"category,omitempty" yaml:"category,omitempty"`
+	Args       string `json:"args" yaml:"args"`
+	ReturnType string `json:"return_type" yaml:"return_type"`
+	Variadic   bool   `json:"variadic" yaml:"variadic"`
+	Volatility string `json:"volatility" yaml:"volatility"`
+	Aggregate  bool   `json:"aggregate" yaml:"aggregate"`
+	Window     bool   `json:"window" yaml:"window"`
+	// Info is the raw (un-rendered) doc comment attached to the builtin, as
+	// written in the source, without any Markdown-to-HTML conversion applied.
+	Info string `json:"info" yaml:"info"`
+}
+
+// funcCategory groups funcDocs under the heading they're documented under.
+// Category is empty when the catalog isn't broken out by category (e.g.
+// aggregates, which are listed as one flat table).
+type funcCategory struct {
+	Category  string    `json:"category,omitempty" yaml:"category,omitempty"`
+	Functions []funcDoc `json:"functions" yaml:"functions"`
+}
+
+// funcCatalog is the extracted, renderer-agnostic model of a function or
+// aggregate list, as produced by catalogFunctions. opCatalog is its operator
+// counterpart.
+type funcCatalog struct {
+	Categorized bool `json:"categorized" yaml:"categorized"`
+	// Filter is the canonical --filter expression applied when producing
+	// this catalog, recorded so the output is reproducible. Empty when no
+	// filter was given.
+	Filter     string         `json:"filter,omitempty" yaml:"filter,omitempty"`
+	Categories []funcCategory `json:"categories" yaml:"categories"`
+}
+
+// Render dispatches to the appropriate Renderer method. It exists so callers
+// like writeRendered don't need to know whether they're rendering a function
+// catalog or an operator catalog.
+func (c funcCatalog) Render(r Renderer) ([]byte, error) { return r.RenderFunctions(c) }
+
+// opDoc is the structured description of a single operator overload.
+type opDoc struct {
+	Op         string `json:"op" yaml:"op"`
+	Left       string `json:"left,omitempty" yaml:"left,omitempty"`
+	Right      string `json:"right,omitempty" yaml:"right,omitempty"`
+	ReturnType string `json:"return_type" yaml:"return_type"`
+}
+
+type opGroup struct {
+	Op        string  `json:"op" yaml:"op"`
+	Overloads []opDoc `json:"overloads" yaml:"overloads"`
+}
+
+// opCatalog is the extracted, renderer-agnostic model of every unary,
+// binary, and comparison operator overload.
+type opCatalog struct {
+	// Filter is the canonical --filter expression applied when producing
+	// this catalog, recorded so the output is reproducible. Empty when no
+	// filter was given.
+	Filter    string    `json:"filter,omitempty" yaml:"filter,omitempty"`
+	Operators []opGroup `json:"operators" yaml:"operators"`
+}
+
+func (c opCatalog) Render(r Renderer) ([]byte, error) { return r.RenderOperators(c) }
+
+// TODO(mjibson): use the exported value from sql/parser/pg_builtins.go.
+const notUsableInfo = "Not usable; exposed only for compatibility with PostgreSQL."
+
+// catalogFunctions extracts a funcCatalog from a parser.Builtin map.
+// categorize controls whether the resulting catalog is broken out by
+// fn.Category() (and whether window functions are skipped, matching the
+// behavior of the plain functions.md list); aggregateSource records whether
+// this catalog documents parser.Aggregates, for the benefit of consumers of
+// the structured output. filterStr is recorded verbatim in the result for
+// reproducibility; filter is evaluated against every overload and decides
+// whether it's kept.
+func catalogFunctions(
+	from map[string][]parser.Builtin, categorize bool, aggregateSource bool,
+	filterStr string, filter filterExpr,
+) funcCatalog {
+	// By default the categorized (functions.md) catalog omits window
+	// functions, since they're documented separately. But a filter that
+	// explicitly asks about is_window (e.g. --only-window) means the caller
+	// wants them, so it overrides this default instead of being evaluated
+	// against an already-emptied set.
+	skipWindowByDefault := categorize && !filter.mentionsField(fieldIsWindow)
+
+	byCategory := make(map[string][]funcDoc)
+	seen := make(map[string]struct{})
+	for name, fns := range from {
+		// NB: funcs can appear more than once i.e. upper/lowercase varients for
+		// faster lookups, so normalize to lowercase and de-dupe using a set.
+		name = strings.ToLower(name)
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		for _, fn := range fns {
+			if fn.Info == notUsableInfo {
+				continue
+			}
+			if skipWindowByDefault && fn.WindowFunc != nil {
+				continue
+			}
+			args := fn.Types.String()
+			ret := fn.FixedReturnType().String()
+			cat := ret
+			if c := fn.Category(); c != "" {
+				cat = c
+			}
+			if !categorize {
+				cat = ""
+			}
+			_, variadic := fn.Types.(parser.VariadicType)
+			doc := funcDoc{
+				Name:       name,
+				Category:   cat,
+				Args:       args,
+				ReturnType: ret,
+				Variadic:   variadic,
+				Volatility: fn.Volatility.String(),
+				Aggregate:  aggregateSource,
+				Window:     fn.WindowFunc != nil,
+				Info:       fn.Info,
+			}
+			if !filter.eval(doc.filterRecord()) {
+				continue
+			}
+			byCategory[cat] = append(byCategory[cat], doc)
+		}
+	}
+	var cats []string
+	for k, v := range byCategory {
+		sort.Slice(v, func(i, j int) bool {
+			if v[i].Name != v[j].Name {
+				return v[i].Name < v[j].Name
+			}
+			if v[i].Args != v[j].Args {
+				return v[i].Args < v[j].Args
+			}
+			return v[i].ReturnType < v[j].ReturnType
+		})
+		byCategory[k] = v
+		cats = append(cats, k)
+	}
+	sort.Strings(cats)
+	// HACK: swap "Compatibility" to be last.
+	// TODO(dt): Break up generated list be one _include per category, to allow
+	// manually written copy on some sections.
+	for i, cat := range cats {
+		if cat == "Compatibility" {
+			cats = append(append(cats[:i], cats[i+1:]...), "Compatibility")
+			break
+		}
+	}
+	doc := funcCatalog{Categorized: categorize, Filter: filterStr}
+	for _, cat := range cats {
+		doc.Categories = append(doc.Categories, funcCategory{Category: cat, Functions: byCategory[cat]})
+	}
+	return doc
+}
+
 type operation struct {
 	left  string
 	right string
@@ -71,13 +394,6 @@ type operation struct {
 	op    string
 }
 
-func (o operation) String() string {
-	if o.right == "" {
-		return fmt.Sprintf("<code>%s</code>%s", o.op, linkTypeName(o.left))
-	}
-	return fmt.Sprintf("%s <code>%s</code> %s", linkTypeName(o.left), o.op, linkTypeName(o.right))
-}
-
 type operations []operation
 
 func (p operations) Len() int      { return len(p) }
@@ -98,7 +414,11 @@ func (p operations) Less(i, j int) bool {
 	return p[i].ret < p[j].ret
 }
 
-func generateOperators() []byte {
+// catalogOperators extracts an opCatalog from parser.UnaryOps, parser.BinOps,
+// and parser.CmpOps. filterStr is recorded verbatim in the result for
+// reproducibility; filter is evaluated against every overload row and
+// decides whether it's kept, the same as in catalogFunctions.
+func catalogOperators(filterStr string, filter filterExpr) opCatalog {
 	ops := make(map[string]operations)
 	for optyp, overloads := range parser.UnaryOps {
 		op := optyp.String()
@@ -145,118 +465,63 @@ func generateOperators() []byte {
 		opstrs = append(opstrs, k)
 	}
 	sort.Strings(opstrs)
-	b := new(bytes.Buffer)
+	doc := opCatalog{Filter: filterStr}
 	for _, op := range opstrs {
-		fmt.Fprintf(b, "<table><thead>\n")
-		fmt.Fprintf(b, "<tr><td><code>%s</code></td><td>Return</td></tr>\n", op)
-		fmt.Fprintf(b, "</thead><tbody>\n")
+		group := opGroup{Op: op}
 		for _, v := range ops[op] {
-			fmt.Fprintf(b, "<tr><td>%s</td><td>%s</td></tr>\n", v.String(), linkTypeName(v.ret))
-		}
-		fmt.Fprintf(b, "</tbody></table>")
-		fmt.Fprintln(b)
-	}
-	return b.Bytes()
-}
-
-// TODO(mjibson): use the exported value from sql/parser/pg_builtins.go.
-const notUsableInfo = "Not usable; exposed only for compatibility with PostgreSQL."
-
-func generateFunctions(from map[string][]parser.Builtin, categorize bool) []byte {
-	functions := make(map[string][]string)
-	seen := make(map[string]struct{})
-	md := markdown.New(markdown.XHTMLOutput(true), markdown.Nofollow(true))
-	for name, fns := range from {
-		// NB: funcs can appear more than once i.e. upper/lowercase varients for
-		// faster lookups, so normalize to lowercase and de-dupe using a set.
-		name = strings.ToLower(name)
-		if _, ok := seen[name]; ok {
-			continue
-		}
-		seen[name] = struct{}{}
-		for _, fn := range fns {
-			if fn.Info == notUsableInfo {
-				continue
+			o := opDoc{
+				Op:         v.op,
+				Left:       v.left,
+				Right:      v.right,
+				ReturnType: v.ret,
 			}
-			if categorize && fn.WindowFunc != nil {
+			if !filter.eval(o.filterRecord()) {
 				continue
 			}
-			args := fn.Types.String()
-			ret := fn.FixedReturnType().String()
-			cat := ret
-			if c := fn.Category(); c != "" {
-				cat = c
-			}
-			if !categorize {
-				cat = ""
-			}
-			extra := ""
-			if fn.Info != "" {
-				// Render the info field to HTML upfront, because Markdown
-				// won't do it automatically in a table context.
-				// Boo Markdown, bad Markdown.
-				// TODO(knz): Do not use Markdown.
-				info := md.RenderToString([]byte(fn.Info))
-				extra = fmt.Sprintf("<span class=\"funcdesc\">%s</span>", info)
-			}
-			s := fmt.Sprintf("<tr><td><code>%s(%s) &rarr; %s</code></td><td>%s</td></tr>", name, linkArguments(args), linkArguments(ret), extra)
-			functions[cat] = append(functions[cat], s)
+			group.Overloads = append(group.Overloads, o)
 		}
-	}
-	var cats []string
-	for k, v := range functions {
-		sort.Strings(v)
-		cats = append(cats, k)
-	}
-	sort.Strings(cats)
-	// HACK: swap "Compatibility" to be last.
-	// TODO(dt): Break up generated list be one _include per category, to allow
-	// manually written copy on some sections.
-	for i, cat := range cats {
-		if cat == "Compatibility" {
-			cats = append(append(cats[:i], cats[i+1:]...), "Compatibility")
-			break
+		if len(group.Overloads) > 0 {
+			doc.Operators = append(doc.Operators, group)
 		}
 	}
-	b := new(bytes.Buffer)
-	for _, cat := range cats {
-		if categorize {
-			fmt.Fprintf(b, "### %s Functions\n\n", cat)
-		}
-		b.WriteString("<table>\n<thead><tr><th>Function &rarr; Returns</th><th>Description</th></tr></thead>\n")
-		b.WriteString("<tbody>\n")
-		b.WriteString(strings.Join(functions[cat], "\n"))
-		b.WriteString("</tbody>\n</table>\n\n")
-	}
-	return b.Bytes()
+	return doc
 }
 
-var linkRE = regexp.MustCompile(`([a-z]+)([\.\[\]]*)$`)
+// dataRenderer renders a catalog as JSON or YAML, serializing the extracted
+// model directly so that downstream tools (IDE autocomplete, SQL linters,
+// client-side query builders) consume the exact same source of truth as the
+// generated docs, without scraping generated HTML tables.
+type dataRenderer struct {
+	ext string
+}
 
-func linkArguments(t string) string {
-	sp := strings.Split(t, ", ")
-	for i, s := range sp {
-		sp[i] = linkRE.ReplaceAllStringFunc(s, func(s string) string {
-			match := linkRE.FindStringSubmatch(s)
-			s = linkTypeName(match[1])
-			return s + match[2]
-		})
-	}
-	return strings.Join(sp, ", ")
+func (r dataRenderer) Ext() string { return r.ext }
+
+func (r dataRenderer) RenderFunctions(doc funcCatalog) ([]byte, error) {
+	return r.marshal(doc)
 }
 
-func linkTypeName(s string) string {
-	s = strings.TrimSuffix(s, "{}")
-	name := s
-	switch s {
-	case "timestamptz":
-		s = "timestamp"
-	}
-	s = strings.TrimSuffix(s, "[]")
-	switch s {
-	case "int", "decimal", "float", "bool", "date", "timestamp", "interval", "string", "bytes",
-		"inet", "uuid", "collatedstring":
-		s = fmt.Sprintf("<a href=\"%s.html\">%s</a>", s, name)
+func (r dataRenderer) RenderOperators(doc opCatalog) ([]byte, error) {
+	return r.marshal(doc)
+}
+
+func (r dataRenderer) marshal(v interface{}) ([]byte, error) {
+	switch r.ext {
+	case "json":
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(b, '\n'), nil
+	case "yaml":
+		return yaml.Marshal(v)
+	default:
+		return nil, errors.Errorf("unknown data format %q", r.ext)
 	}
-	return s
 }
+
+// linkRE matches the base type name (and any trailing "[", "]" or "."
+// characters, e.g. an array suffix) at the end of a rendered type string,
+// e.g. "string[]". A type string ending in a collation suffix such as
+// "collatedstring{}" does not match. It's used by the argList template helper.
+var linkRE = regexp.MustCompile(`([a-z]+)([\.\[\]]*)$`)
@@ -0,0 +1,241 @@
+// Copyright 2017 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// defaultTypeLinks maps a SQL type name to the basename of the docs page it
+// links to. It covers the built-in scalar types; pass a YAML file via
+// --type-links to extend or override it with types the generator doesn't
+// know about by name (jsonb, tuple, oid, regproc, array element types,
+// collated string variants, ...).
+var defaultTypeLinks = map[string]string{
+	"int":            "int",
+	"decimal":        "decimal",
+	"float":          "float",
+	"bool":           "bool",
+	"date":           "date",
+	"timestamp":      "timestamp",
+	"timestamptz":    "timestamp",
+	"interval":       "interval",
+	"string":         "string",
+	"bytes":          "bytes",
+	"inet":           "inet",
+	"uuid":           "uuid",
+	"collatedstring": "collatedstring",
+}
+
+// loadTypeLinks reads a YAML file of `type: page-basename` entries and
+// merges it over defaultTypeLinks. An empty path returns defaultTypeLinks
+// unmodified.
+func loadTypeLinks(path string) (map[string]string, error) {
+	links := make(map[string]string, len(defaultTypeLinks))
+	for k, v := range defaultTypeLinks {
+		links[k] = v
+	}
+	if path == "" {
+		return links, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading --type-links %q", path)
+	}
+	var overrides map[string]string
+	if err := yaml.Unmarshal(b, &overrides); err != nil {
+		return nil, errors.Wrapf(err, "parsing --type-links %q", path)
+	}
+	for k, v := range overrides {
+		links[k] = v
+	}
+	return links, nil
+}
+
+// linkType renders a single type name as an HTML link to its docs page,
+// using the Renderer's type-link map, falling back to the bare name for
+// types the map doesn't know about. It strips a trailing collation ("{}")
+// suffix and then an array-element ("[]") suffix before looking the base
+// type up; the link text keeps the "[]". This matches the historical
+// behavior of linkTypeName.
+func linkType(links map[string]string) func(string) string {
+	return func(s string) string {
+		s = strings.TrimSuffix(s, "{}")
+		name := s
+		s = strings.TrimSuffix(s, "[]")
+		if page, ok := links[s]; ok {
+			return fmt.Sprintf("<a href=\"%s.html\">%s</a>", page, name)
+		}
+		return name
+	}
+}
+
+// argList links every comma-separated type in a function's argument (or
+// return) type string, e.g. "int, string[]" -> "<a ...>int</a>, <a
+// ...>string</a>[]".
+func argList(linkFn func(string) string) func(string) string {
+	return func(t string) string {
+		sp := strings.Split(t, ", ")
+		for i, s := range sp {
+			sp[i] = linkRE.ReplaceAllStringFunc(s, func(s string) string {
+				match := linkRE.FindStringSubmatch(s)
+				return linkFn(match[1]) + match[2]
+			})
+		}
+		return strings.Join(sp, ", ")
+	}
+}
+
+// sigString renders a funcDoc as the "name(args) &rarr; returnType"
+// signature that's the first column of the functions tables. The return
+// type goes through argListFn, not linkFn directly, so an array/collated
+// return type links like "<a ...>string</a>[]" rather than
+// "<a ...>string[]</a>", matching how the argument column links types.
+func sigString(argListFn func(string) string) func(funcDoc) string {
+	return func(fn funcDoc) string {
+		return fmt.Sprintf("%s(%s) &rarr; %s", fn.Name, argListFn(fn.Args), argListFn(fn.ReturnType))
+	}
+}
+
+// renderInfo renders a builtin's raw Info doc comment to HTML, since
+// Markdown won't do it automatically inside a table cell, and wraps it in
+// the span the docs site's CSS styles as a function description.
+//
+// TODO(knz): Do not use Markdown.
+func renderInfo(info string) string {
+	if info == "" {
+		return ""
+	}
+	rendered := mdRenderer.RenderToString([]byte(info))
+	return fmt.Sprintf("<span class=\"funcdesc\">%s</span>", rendered)
+}
+
+// templateFuncs builds the text/template.FuncMap exposed to --template
+// files, bound to the given type-link map.
+func templateFuncs(links map[string]string) template.FuncMap {
+	link := linkType(links)
+	args := argList(link)
+	return template.FuncMap{
+		"linkType":   link,
+		"argList":    args,
+		"sigString":  sigString(args),
+		"renderInfo": renderInfo,
+	}
+}
+
+// defaultFuncsTemplate reproduces, as a text/template, the Markdown the
+// generator produced before it became template-driven. --template overrides
+// it with a user-supplied template executed against the same funcCatalog.
+// Rows are joined with a leading "\n" (rather than each row trailing one) so
+// the last row butts up against </tbody> with no blank line between them,
+// matching the historical strings.Join(rows, "\n") output byte-for-byte.
+const defaultFuncsTemplate = `{{range .Categories}}` +
+	`{{if $.Categorized}}### {{.Category}} Functions
+
+{{end}}` +
+	`<table>
+<thead><tr><th>Function &rarr; Returns</th><th>Description</th></tr></thead>
+<tbody>
+{{range $i, $fn := .Functions}}{{if $i}}
+{{end}}<tr><td><code>{{sigString $fn}}</code></td><td>{{renderInfo $fn.Info}}</td></tr>{{end}}</tbody>
+</table>
+
+{{end}}`
+
+// defaultOperatorsTemplate is --operators-template's default, reproducing
+// the historical operators.md output byte-for-byte. Unlike in
+// defaultFuncsTemplate, every row ends in "\n", including the last one
+// before </tbody>.
+const defaultOperatorsTemplate = `{{range .Operators}}<table><thead>
+<tr><td><code>{{.Op}}</code></td><td>Return</td></tr>
+</thead><tbody>
+{{range $o := .Overloads}}<tr><td>{{if $o.Right}}{{linkType $o.Left}} <code>{{$o.Op}}</code> {{linkType $o.Right}}{{else}}<code>{{$o.Op}}</code>{{linkType $o.Left}}{{end}}</td><td>{{linkType $o.ReturnType}}</td></tr>
+{{end}}</tbody></table>
+{{end}}`
+
+func parseTemplate(name, path, fallback string, funcs template.FuncMap) (*template.Template, error) {
+	if path == "" {
+		return template.New(name).Funcs(funcs).Parse(fallback)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading template %q", path)
+	}
+	return template.New(name).Funcs(funcs).Parse(string(b))
+}
+
+// templateRenderer is the Renderer backing --format=md: a typed model
+// (funcCatalog / opCatalog) executed against a text/template, defaulting to
+// defaultFuncsTemplate / defaultOperatorsTemplate but overridable with
+// --template and --operators-template so the generator can produce
+// reference pages in other site themes.
+type templateRenderer struct {
+	funcsTmpl *template.Template
+	opsTmpl   *template.Template
+}
+
+func newTemplateRenderer(funcsTemplatePath, opsTemplatePath, typeLinksPath string) (templateRenderer, error) {
+	links, err := loadTypeLinks(typeLinksPath)
+	if err != nil {
+		return templateRenderer{}, err
+	}
+	funcs := templateFuncs(links)
+	funcsTmpl, err := parseTemplate("funcs", funcsTemplatePath, defaultFuncsTemplate, funcs)
+	if err != nil {
+		return templateRenderer{}, err
+	}
+	opsTmpl, err := parseTemplate("operators", opsTemplatePath, defaultOperatorsTemplate, funcs)
+	if err != nil {
+		return templateRenderer{}, err
+	}
+	return templateRenderer{funcsTmpl: funcsTmpl, opsTmpl: opsTmpl}, nil
+}
+
+func (templateRenderer) Ext() string { return "md" }
+
+func (r templateRenderer) RenderFunctions(doc funcCatalog) ([]byte, error) {
+	b := new(bytes.Buffer)
+	writeFilterComment(b, doc.Filter)
+	if err := r.funcsTmpl.Execute(b, doc); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func (r templateRenderer) RenderOperators(doc opCatalog) ([]byte, error) {
+	b := new(bytes.Buffer)
+	writeFilterComment(b, doc.Filter)
+	if err := r.opsTmpl.Execute(b, doc); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// writeFilterComment records the --filter expression that produced a
+// Markdown file as an HTML comment, so the output is reproducible without
+// needing the original invocation.
+func writeFilterComment(b *bytes.Buffer, filter string) {
+	if filter == "" {
+		return
+	}
+	fmt.Fprintf(b, "<!-- filter: %s -->\n\n", filter)
+}